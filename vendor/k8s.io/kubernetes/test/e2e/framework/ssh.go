@@ -26,22 +26,33 @@ import (
 
 	"golang.org/x/crypto/ssh"
 	v1 "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/util/wait"
 	clientset "k8s.io/client-go/kubernetes"
 	sshutil "k8s.io/kubernetes/pkg/ssh"
 )
 
 // GetSigner returns an ssh.Signer for the provider ("gce", etc.) that can be
-// used to SSH to their nodes.
+// used to SSH to their nodes. Prefer GetSigners, which also discovers keys via
+// ssh_config and the SSH agent instead of relying solely on the provider's
+// hardcoded default filename.
 func GetSigner(provider string) (ssh.Signer, error) {
+	keyfile, err := providerKeyfile(provider)
+	if err != nil {
+		return nil, err
+	}
+	return sshutil.MakePrivateKeySignerFromFile(keyfile)
+}
+
+// providerKeyfile resolves the legacy, provider-specific private key path: a
+// consistent override via KUBE_SSH_KEY_PATH if set, otherwise a per-provider
+// environment variable or hardcoded filename under ~/.ssh. When implementing
+// more providers here, please also add them to any SSH tests that are
+// disabled because of signer support.
+func providerKeyfile(provider string) (string, error) {
 	// honor a consistent SSH key across all providers
 	if path := os.Getenv("KUBE_SSH_KEY_PATH"); len(path) > 0 {
-		return sshutil.MakePrivateKeySignerFromFile(path)
+		return path, nil
 	}
 
-	// Select the key itself to use. When implementing more providers here,
-	// please also add them to any SSH tests that are disabled because of signer
-	// support.
 	keyfile := ""
 	switch provider {
 	case "gce", "gke", "kubemark":
@@ -65,7 +76,7 @@ func GetSigner(provider string) (ssh.Signer, error) {
 			keyfile = "id_rsa"
 		}
 	default:
-		return nil, fmt.Errorf("GetSigner(...) not implemented for %s", provider)
+		return "", fmt.Errorf("GetSigner(...) not implemented for %s", provider)
 	}
 
 	// Respect absolute paths for keys given by user, fallback to assuming
@@ -75,7 +86,7 @@ func GetSigner(provider string) (ssh.Signer, error) {
 		keyfile = filepath.Join(keydir, keyfile)
 	}
 
-	return sshutil.MakePrivateKeySignerFromFile(keyfile)
+	return keyfile, nil
 }
 
 // NodeSSHHosts returns SSH-able host names for all schedulable nodes - this
@@ -133,28 +144,24 @@ func NodeExec(nodeName, cmd string) (SSHResult, error) {
 func SSH(cmd, host, provider string) (SSHResult, error) {
 	result := SSHResult{Host: host, Cmd: cmd}
 
-	// Get a signer for the provider.
-	signer, err := GetSigner(provider)
+	// Discover the auth methods usable for this host: ssh_config and default
+	// identity files, plus an SSH agent fallback.
+	authMethods, err := GetSigners(provider, host)
 	if err != nil {
-		return result, fmt.Errorf("error getting signer for provider %s: '%v'", provider, err)
+		return result, fmt.Errorf("error getting signers for provider %s: '%v'", provider, err)
 	}
 
-	// RunSSHCommand will default to Getenv("USER") if user == "", but we're
-	// defaulting here as well for logging clarity.
-	result.User = os.Getenv("KUBE_SSH_USER")
-	if result.User == "" {
-		result.User = os.Getenv("USER")
-	}
+	result.User = sshUser()
 
 	if bastion := os.Getenv("KUBE_SSH_BASTION"); len(bastion) > 0 {
-		stdout, stderr, code, err := RunSSHCommandViaBastion(cmd, result.User, bastion, host, signer)
+		stdout, stderr, code, err := RunSSHCommandViaBastion(cmd, result.User, bastion, host, authMethods)
 		result.Stdout = stdout
 		result.Stderr = stderr
 		result.Code = code
 		return result, err
 	}
 
-	stdout, stderr, code, err := sshutil.RunSSHCommand(cmd, result.User, host, signer)
+	stdout, stderr, code, err := runSSHCommand(cmd, result.User, host, authMethods)
 	result.Stdout = stdout
 	result.Stderr = stderr
 	result.Code = code
@@ -162,47 +169,69 @@ func SSH(cmd, host, provider string) (SSHResult, error) {
 	return result, err
 }
 
-// RunSSHCommandViaBastion returns the stdout, stderr, and exit code from running cmd on
-// host as specific user, along with any SSH-level error. It uses an SSH proxy to connect
-// to bastion, then via that tunnel connects to the remote host. Similar to
-// sshutil.RunSSHCommand but scoped to the needs of the test infrastructure.
-func RunSSHCommandViaBastion(cmd, user, bastion, host string, signer ssh.Signer) (string, string, int, error) {
-	// Setup the config, dial the server, and open a session.
-	config := &ssh.ClientConfig{
-		User:            user,
-		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-		Timeout:         150 * time.Second,
+// sshUser returns the user SSH commands should connect as: KUBE_SSH_USER if
+// set, falling back to the local user the test binary runs as.
+func sshUser() string {
+	if user := os.Getenv("KUBE_SSH_USER"); user != "" {
+		return user
 	}
-	bastionClient, err := ssh.Dial("tcp", bastion, config)
-	if err != nil {
-		err = wait.Poll(5*time.Second, 20*time.Second, func() (bool, error) {
-			fmt.Printf("error dialing %s@%s: '%v', retrying\n", user, bastion, err)
-			if bastionClient, err = ssh.Dial("tcp", bastion, config); err != nil {
-				return false, err
-			}
-			return true, nil
+	return os.Getenv("USER")
+}
+
+// runSSHCommand gets a (possibly pooled) client to host and runs cmd, trying
+// each of authMethods in turn the way an interactive ssh(1) client would.
+func runSSHCommand(cmd, user, host string, authMethods []ssh.AuthMethod) (string, string, int, error) {
+	dial := func() (*ssh.Client, error) {
+		hostKeyCb, err := hostKeyCallback()
+		if err != nil {
+			return nil, fmt.Errorf("error configuring host key verification: %v", err)
+		}
+		return ssh.Dial("tcp", host, &ssh.ClientConfig{
+			User:            user,
+			Auth:            authMethods,
+			HostKeyCallback: hostKeyCb,
+			Timeout:         150 * time.Second,
 		})
 	}
+
+	session, err := pooledSession(sshClientKey{user: user, host: host}, &sshPool, dial)
 	if err != nil {
-		return "", "", 0, fmt.Errorf("error getting SSH client to %s@%s: %v", user, bastion, err)
+		return "", "", 0, fmt.Errorf("error creating session to %s@%s: '%v'", user, host, err)
 	}
-	defer bastionClient.Close()
+	defer session.Close()
 
-	conn, err := bastionClient.Dial("tcp", host)
-	if err != nil {
-		return "", "", 0, fmt.Errorf("error dialing %s from bastion: %v", host, err)
+	code := 0
+	var bout, berr bytes.Buffer
+	session.Stdout, session.Stderr = &bout, &berr
+	if err = session.Run(cmd); err != nil {
+		if exiterr, ok := err.(*ssh.ExitError); ok {
+			if code = exiterr.ExitStatus(); code != 0 {
+				err = nil
+			}
+		} else {
+			err = fmt.Errorf("failed running `%s` on %s@%s: '%v'", cmd, user, host, err)
+		}
 	}
-	defer conn.Close()
+	return bout.String(), berr.String(), code, err
+}
 
-	ncc, chans, reqs, err := ssh.NewClientConn(conn, host, config)
+// RunSSHCommandViaBastion returns the stdout, stderr, and exit code from running cmd on
+// host as specific user, along with any SSH-level error. It uses an SSH proxy to connect
+// to bastion, then via that tunnel connects to the remote host. Similar to
+// runSSHCommand but scoped to the needs of the test infrastructure.
+func RunSSHCommandViaBastion(cmd, user, bastion, host string, authMethods []ssh.AuthMethod) (string, string, int, error) {
+	hostKeyCb, err := hostKeyCallback()
 	if err != nil {
-		return "", "", 0, fmt.Errorf("error creating forwarding connection %s from bastion: %v", host, err)
+		return "", "", 0, fmt.Errorf("error configuring host key verification: %v", err)
+	}
+	config := &ssh.ClientConfig{
+		User:            user,
+		Auth:            authMethods,
+		HostKeyCallback: hostKeyCb,
+		Timeout:         150 * time.Second,
 	}
-	client := ssh.NewClient(ncc, chans, reqs)
-	defer client.Close()
 
-	session, err := client.NewSession()
+	session, err := sessionViaBastion(user, bastion, host, config)
 	if err != nil {
 		return "", "", 0, fmt.Errorf("error creating session to %s@%s from bastion: '%v'", user, host, err)
 	}
@@ -0,0 +1,280 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package framework
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// signerCache memoizes the ssh.Signer loaded for each identity file path, so
+// that e2e suites touching many nodes with the same key only parse (and, for
+// agent-backed encrypted keys, look up) it once.
+var signerCache sync.Map // map[string]ssh.Signer
+
+// GetSigners returns the ssh.AuthMethods usable to connect to host on
+// provider, discovering identity files the way ssh(1) does: the provider's
+// legacy key (see GetSigner) takes precedence if it resolves, then any
+// IdentityFile entries matching host in ~/.ssh/ssh_config and
+// /etc/ssh/ssh_config, then a fixed list of default key paths. If
+// SSH_AUTH_SOCK is set, the agent is appended as a further fallback method,
+// and is also consulted to unlock identity files that are encrypted on disk.
+func GetSigners(provider, host string) ([]ssh.AuthMethod, error) {
+	ag := dialSSHAgent()
+
+	hostname := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		hostname = h
+	}
+
+	var paths []string
+	if legacy, err := providerKeyfile(provider); err == nil {
+		paths = append(paths, legacy)
+	}
+	paths = append(paths, identityFilesForHost(sshConfigPaths(), hostname)...)
+	paths = append(paths, defaultIdentityFiles()...)
+
+	var agentKeys []ssh.PublicKey
+	if ag != nil {
+		if signers, err := ag.Signers(); err == nil {
+			for _, s := range signers {
+				agentKeys = append(agentKeys, s.PublicKey())
+			}
+		}
+	}
+
+	var methods []ssh.AuthMethod
+	var lastErr error
+	seen := map[string]bool{}
+	for _, path := range paths {
+		if path == "" || seen[path] {
+			continue
+		}
+		seen[path] = true
+		signer, err := signerForPath(path, ag)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if agentHasKey(agentKeys, signer.PublicKey()) {
+			// The agent will offer this key itself; offering it again here
+			// risks tripping the server's MaxAuthTries with duplicate keys.
+			continue
+		}
+		methods = append(methods, ssh.PublicKeys(signer))
+	}
+
+	if ag != nil {
+		methods = append(methods, ssh.PublicKeysCallback(ag.Signers))
+	}
+
+	if len(methods) == 0 {
+		if lastErr != nil {
+			return nil, fmt.Errorf("no usable SSH identities found for %s: %v", host, lastErr)
+		}
+		return nil, fmt.Errorf("no usable SSH identities found for %s", host)
+	}
+	return methods, nil
+}
+
+// defaultIdentityFiles lists the identity files ssh(1) tries when none is
+// configured for a host, in the order it tries them.
+func defaultIdentityFiles() []string {
+	home := os.Getenv("HOME")
+	return []string{
+		filepath.Join(home, ".ssh", "id_ed25519"),
+		filepath.Join(home, ".ssh", "id_rsa"),
+		filepath.Join(home, ".ssh", "identity"),
+	}
+}
+
+// sshConfigPaths returns the ssh_config files to consult, most specific
+// first, mirroring the search order of ssh(1).
+func sshConfigPaths() []string {
+	return []string{
+		filepath.Join(os.Getenv("HOME"), ".ssh", "ssh_config"),
+		"/etc/ssh/ssh_config",
+	}
+}
+
+// identityFilesForHost returns the IdentityFile values of every Host block in
+// configPaths that matches host, in file and block order.
+func identityFilesForHost(configPaths []string, host string) []string {
+	var files []string
+	for _, path := range configPaths {
+		f, err := os.Open(path)
+		if err != nil {
+			continue
+		}
+		files = append(files, parseIdentityFiles(f, host)...)
+		f.Close()
+	}
+	return files
+}
+
+// parseIdentityFiles implements just enough of the ssh_config(5) grammar —
+// "Host" blocks and the "IdentityFile" keyword — to resolve identity files
+// for host. It is not a general-purpose ssh_config parser.
+func parseIdentityFiles(r io.Reader, host string) []string {
+	var files []string
+	matched := false
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := splitConfigLine(line)
+		if !ok {
+			continue
+		}
+		switch strings.ToLower(key) {
+		case "host":
+			matched = hostPatternMatches(value, host)
+		case "identityfile":
+			if matched {
+				files = append(files, expandHome(value))
+			}
+		}
+	}
+	return files
+}
+
+// splitConfigLine splits a single ssh_config line into its keyword and
+// argument, accepting both "Key Value" and "Key=Value" forms.
+func splitConfigLine(line string) (key, value string, ok bool) {
+	line = strings.Replace(line, "=", " ", 1)
+	fields := strings.Fields(line)
+	if len(fields) < 2 {
+		return "", "", false
+	}
+	return fields[0], strings.Trim(strings.Join(fields[1:], " "), `"`), true
+}
+
+// hostPatternMatches reports whether host matches any of the space-separated,
+// possibly negated, glob patterns in an ssh_config "Host" line. A matching
+// negated pattern rejects host regardless of where it appears on the line.
+func hostPatternMatches(patterns, host string) bool {
+	matched := false
+	for _, p := range strings.Fields(patterns) {
+		negate := strings.HasPrefix(p, "!")
+		if negate {
+			p = p[1:]
+		}
+		if ok, err := filepath.Match(p, host); err == nil && ok {
+			if negate {
+				return false
+			}
+			matched = true
+		}
+	}
+	return matched
+}
+
+func expandHome(path string) string {
+	if strings.HasPrefix(path, "~/") {
+		return filepath.Join(os.Getenv("HOME"), path[2:])
+	}
+	return path
+}
+
+// dialSSHAgent returns a client for the agent listening on SSH_AUTH_SOCK, or
+// nil if the variable is unset or the agent can't be reached.
+func dialSSHAgent() agent.Agent {
+	sock := os.Getenv("SSH_AUTH_SOCK")
+	if sock == "" {
+		return nil
+	}
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		Logf("error dialing SSH_AUTH_SOCK %s: %v", sock, err)
+		return nil
+	}
+	return agent.NewClient(conn)
+}
+
+// signerForPath loads and caches the ssh.Signer for the private key at path.
+// If the key is encrypted, rather than prompting for a passphrase it looks
+// for a signer on ag whose public key matches path+".pub".
+func signerForPath(path string, ag agent.Agent) (ssh.Signer, error) {
+	if cached, ok := signerCache.Load(path); ok {
+		return cached.(ssh.Signer), nil
+	}
+
+	keyData, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, err := ssh.ParsePrivateKey(keyData)
+	if _, encrypted := err.(*ssh.PassphraseMissingError); encrypted {
+		signer, err = agentSignerForPublicKey(path+".pub", ag)
+		if err != nil {
+			return nil, fmt.Errorf("%s is encrypted: %v", path, err)
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	signerCache.Store(path, signer)
+	return signer, nil
+}
+
+// agentHasKey reports whether key's marshaled form matches one of agentKeys.
+func agentHasKey(agentKeys []ssh.PublicKey, key ssh.PublicKey) bool {
+	for _, k := range agentKeys {
+		if bytes.Equal(k.Marshal(), key.Marshal()) {
+			return true
+		}
+	}
+	return false
+}
+
+func agentSignerForPublicKey(pubPath string, ag agent.Agent) (ssh.Signer, error) {
+	if ag == nil {
+		return nil, fmt.Errorf("no SSH agent available to unlock it")
+	}
+	pubData, err := ioutil.ReadFile(pubPath)
+	if err != nil {
+		return nil, fmt.Errorf("its public key could not be read: %v", err)
+	}
+	want, _, _, _, err := ssh.ParseAuthorizedKey(pubData)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing its public key: %v", err)
+	}
+	signers, err := ag.Signers()
+	if err != nil {
+		return nil, fmt.Errorf("error listing agent keys: %v", err)
+	}
+	for _, s := range signers {
+		if bytes.Equal(s.PublicKey().Marshal(), want.Marshal()) {
+			return s, nil
+		}
+	}
+	return nil, fmt.Errorf("no matching key found on the SSH agent")
+}
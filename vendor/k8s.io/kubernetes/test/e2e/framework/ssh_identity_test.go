@@ -0,0 +1,116 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package framework
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSplitConfigLine(t *testing.T) {
+	cases := []struct {
+		line      string
+		wantKey   string
+		wantValue string
+		wantOK    bool
+	}{
+		{"IdentityFile ~/.ssh/id_ed25519", "IdentityFile", "~/.ssh/id_ed25519", true},
+		{"IdentityFile=~/.ssh/id_ed25519", "IdentityFile", "~/.ssh/id_ed25519", true},
+		{`Host "my host"`, "Host", "my host", true},
+		{"Host   gce-*   ", "Host", "gce-*", true},
+		{"IdentityFile", "", "", false},
+		{"", "", "", false},
+	}
+	for _, c := range cases {
+		key, value, ok := splitConfigLine(c.line)
+		if ok != c.wantOK || key != c.wantKey || value != c.wantValue {
+			t.Errorf("splitConfigLine(%q) = %q, %q, %v; want %q, %q, %v",
+				c.line, key, value, ok, c.wantKey, c.wantValue, c.wantOK)
+		}
+	}
+}
+
+func TestHostPatternMatches(t *testing.T) {
+	cases := []struct {
+		patterns string
+		host     string
+		want     bool
+	}{
+		{"gce-*", "gce-node-1", true},
+		{"gce-*", "aws-node-1", false},
+		{"*", "anything", true},
+		{"gce-* !gce-master", "gce-master", false},
+		{"gce-* !gce-master", "gce-node-1", true},
+		{"bastion.example.com other.example.com", "other.example.com", true},
+		{"bastion.example.com", "other.example.com", false},
+	}
+	for _, c := range cases {
+		if got := hostPatternMatches(c.patterns, c.host); got != c.want {
+			t.Errorf("hostPatternMatches(%q, %q) = %v, want %v", c.patterns, c.host, got, c.want)
+		}
+	}
+}
+
+func TestExpandHome(t *testing.T) {
+	home := os.Getenv("HOME")
+	cases := []struct {
+		path string
+		want string
+	}{
+		{"~/.ssh/id_rsa", filepath.Join(home, ".ssh", "id_rsa")},
+		{"/etc/ssh/known_hosts", "/etc/ssh/known_hosts"},
+		{"relative/path", "relative/path"},
+	}
+	for _, c := range cases {
+		if got := expandHome(c.path); got != c.want {
+			t.Errorf("expandHome(%q) = %q, want %q", c.path, got, c.want)
+		}
+	}
+}
+
+func TestParseIdentityFiles(t *testing.T) {
+	config := strings.NewReader(`
+# comment, ignored
+Host gce-*
+  IdentityFile ~/.ssh/gce_key
+  IdentityFile ~/.ssh/gce_key_backup
+
+Host bastion
+  IdentityFile ~/.ssh/bastion_key
+
+Host *
+  IdentityFile ~/.ssh/fallback_key
+`)
+
+	home := os.Getenv("HOME")
+	got := parseIdentityFiles(config, "gce-node-1")
+	want := []string{
+		filepath.Join(home, ".ssh", "gce_key"),
+		filepath.Join(home, ".ssh", "gce_key_backup"),
+		filepath.Join(home, ".ssh", "fallback_key"),
+	}
+	if len(got) != len(want) {
+		t.Fatalf("parseIdentityFiles() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("parseIdentityFiles()[%d] = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,247 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package framework
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// Every e2e suite re-dialing and re-handshaking a fresh TCP+SSH connection
+// (and, via a bastion, two) for each SSH call is a dominant cost at scale, so
+// clients are kept around and reused across calls for maxSSHClientIdle /
+// maxSSHClientLifetime.
+const (
+	maxSSHClientIdle     = 5 * time.Minute
+	maxSSHClientLifetime = 30 * time.Minute
+)
+
+type sshClientKey struct {
+	user    string
+	host    string
+	bastion string
+}
+
+// pooledSSHClient tracks a cached *ssh.Client alongside the bookkeeping
+// needed to expire it.
+type pooledSSHClient struct {
+	client    *ssh.Client
+	createdAt time.Time
+	lastUsed  time.Time
+}
+
+func (p *pooledSSHClient) expired(now time.Time) bool {
+	return now.Sub(p.createdAt) > maxSSHClientLifetime || now.Sub(p.lastUsed) > maxSSHClientIdle
+}
+
+var (
+	sshPool     sync.Map // sshClientKey -> *pooledSSHClient, final destination clients
+	bastionPool sync.Map // sshClientKey (host == "") -> *pooledSSHClient, shared bastion connections
+
+	// dialLocks holds a *sync.Mutex per sshClientKey, serializing dial-and-cache
+	// for that key only. Keying the lock this way - rather than one mutex
+	// shared across every destination - means a cold or hung dial to one host
+	// (ssh.Dial's timeout is 150s) doesn't stall SSH calls to every other host.
+	dialLocks sync.Map
+)
+
+// dialLock returns the *sync.Mutex serializing dial-and-cache for key,
+// creating it on first use.
+func dialLock(key sshClientKey) *sync.Mutex {
+	v, _ := dialLocks.LoadOrStore(key, &sync.Mutex{})
+	return v.(*sync.Mutex)
+}
+
+// ShutdownSSHPool closes every client cached by the SSH connection pool,
+// including bastion connections. It should be called from framework teardown
+// once a test run is done issuing SSH commands.
+func ShutdownSSHPool() {
+	closeAll := func(key, value interface{}) bool {
+		value.(*pooledSSHClient).client.Close()
+		return true
+	}
+	sshPool.Range(closeAll)
+	sshPool = sync.Map{}
+	bastionPool.Range(closeAll)
+	bastionPool = sync.Map{}
+}
+
+// pooledClient returns a live *ssh.Client for key, dialing via dial and
+// caching the result if there isn't already an unexpired one cached. Only
+// concurrent callers for the same key serialize against each other; dials to
+// other keys proceed independently.
+func pooledClient(key sshClientKey, pool *sync.Map, dial func() (*ssh.Client, error)) (*ssh.Client, error) {
+	mu := dialLock(key)
+	mu.Lock()
+	defer mu.Unlock()
+
+	now := time.Now()
+	if v, ok := pool.Load(key); ok {
+		pooled := v.(*pooledSSHClient)
+		if !pooled.expired(now) {
+			pooled.lastUsed = now
+			return pooled.client, nil
+		}
+		pooled.client.Close()
+		pool.Delete(key)
+	}
+
+	client, err := dial()
+	if err != nil {
+		return nil, err
+	}
+	pool.Store(key, &pooledSSHClient{client: client, createdAt: now, lastUsed: now})
+	return client, nil
+}
+
+// evictPooledClient closes and removes the cached client for key, if any. It
+// is used when a caller observes the connection itself - not just one
+// session on it - is unhealthy, i.e. when pooledSession or sessionViaBastion
+// find the transport dead.
+func evictPooledClient(key sshClientKey, pool *sync.Map) {
+	mu := dialLock(key)
+	mu.Lock()
+	defer mu.Unlock()
+	if v, ok := pool.Load(key); ok {
+		v.(*pooledSSHClient).client.Close()
+		pool.Delete(key)
+	}
+}
+
+// clientAlive reports whether client's underlying transport is still up, by
+// round-tripping a bogus global request over it. A dead transport surfaces as
+// an error here; a live one answers, at worst with a rejection (the server
+// didn't recognize the request type), which comes back as ok == false with a
+// nil error.
+// This lets pooledSession/sessionViaBastion tell a dead connection apart from
+// a merely-refused session (e.g. the server's MaxSessions limit), which is a
+// per-session condition the client is otherwise healthy and shared by.
+func clientAlive(client *ssh.Client) bool {
+	_, _, err := client.SendRequest("keepalive@e2e-framework", true, nil)
+	return err == nil
+}
+
+// pooledSession returns a new *ssh.Session on a (possibly pooled) client to
+// key, dialing via dial. A pooled client refreshes lastUsed on every hit and
+// so never idle-expires merely from being used, which means a connection
+// that the remote end has silently closed can otherwise poison every call
+// for this key until its lifetime cap expires. So if NewSession fails and
+// clientAlive confirms the transport itself is gone, the client is evicted
+// and redialed once before giving up; if the transport is still alive, the
+// failure is assumed to be a per-session limit and NOT cause for closing a
+// client other callers may be using concurrently.
+func pooledSession(key sshClientKey, pool *sync.Map, dial func() (*ssh.Client, error)) (*ssh.Session, error) {
+	client, err := pooledClient(key, pool, dial)
+	if err != nil {
+		return nil, err
+	}
+	session, err := client.NewSession()
+	if err == nil {
+		return session, nil
+	}
+	if clientAlive(client) {
+		return nil, err
+	}
+
+	evictPooledClient(key, pool)
+	client, err = pooledClient(key, pool, dial)
+	if err != nil {
+		return nil, err
+	}
+	return client.NewSession()
+}
+
+// dialViaBastion returns a (possibly pooled) client to host tunneled through
+// bastion, dialing and caching the bastion connection under its own key so
+// it's reused across every inner destination dialed through it.
+func dialViaBastion(user, bastion, host string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	bastionClient, err := pooledClient(sshClientKey{user: user, host: bastion}, &bastionPool, func() (*ssh.Client, error) {
+		client, err := ssh.Dial("tcp", bastion, config)
+		if err != nil {
+			err = wait.Poll(5*time.Second, 20*time.Second, func() (bool, error) {
+				fmt.Printf("error dialing %s@%s: '%v', retrying\n", user, bastion, err)
+				if client, err = ssh.Dial("tcp", bastion, config); err != nil {
+					return false, err
+				}
+				return true, nil
+			})
+		}
+		return client, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error getting SSH client to %s@%s: %v", user, bastion, err)
+	}
+
+	return pooledClient(sshClientKey{user: user, host: host, bastion: bastion}, &sshPool, func() (*ssh.Client, error) {
+		conn, err := bastionClient.Dial("tcp", host)
+		if err != nil {
+			return nil, fmt.Errorf("error dialing %s from bastion: %v", host, err)
+		}
+		ncc, chans, reqs, err := ssh.NewClientConn(conn, host, config)
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("error creating forwarding connection %s from bastion: %v", host, err)
+		}
+		return ssh.NewClient(ncc, chans, reqs), nil
+	})
+}
+
+// sessionViaBastion is pooledSession's counterpart for connections tunneled
+// through bastion: if NewSession fails and clientAlive shows the transport
+// itself is gone, both the destination and bastion pool entries are evicted
+// and the tunnel is redialed once before giving up. dialViaBastion already
+// does its own locking per pool, so this just calls it again rather than
+// going through pooledSession, which would double-dial the bastion on
+// eviction.
+func sessionViaBastion(user, bastion, host string, config *ssh.ClientConfig) (*ssh.Session, error) {
+	client, err := dialViaBastion(user, bastion, host, config)
+	if err != nil {
+		return nil, err
+	}
+	session, err := client.NewSession()
+	if err == nil {
+		return session, nil
+	}
+	if clientAlive(client) {
+		return nil, err
+	}
+
+	evictPooledClient(sshClientKey{user: user, host: host, bastion: bastion}, &sshPool)
+	evictPooledClient(sshClientKey{user: user, host: bastion}, &bastionPool)
+	client, err = dialViaBastion(user, bastion, host, config)
+	if err != nil {
+		return nil, err
+	}
+	return client.NewSession()
+}
+
+// hostKeyCallback verifies against the known_hosts file named by
+// KUBE_SSH_KNOWN_HOSTS if set, and otherwise preserves the historical,
+// insecure "accept any host key" behavior.
+func hostKeyCallback() (ssh.HostKeyCallback, error) {
+	path := os.Getenv("KUBE_SSH_KNOWN_HOSTS")
+	if path == "" {
+		return ssh.InsecureIgnoreHostKey(), nil
+	}
+	return knownhosts.New(path)
+}
@@ -0,0 +1,103 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package framework
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// SSHUntil repeatedly issues cmd via SSH against host on provider until check
+// returns nil for the result or timeout elapses, sleeping interval between
+// attempts. It returns the last SSHResult observed. This mirrors the
+// retry-driven health-probe pattern used throughout the e2e suite (e.g.
+// polling `etcdctl cluster-health` until every member reports healthy) and
+// should replace the ad-hoc wait.Poll+SSH loops scattered across tests.
+func SSHUntil(cmd, host, provider string, check func(SSHResult) error, timeout, interval time.Duration) (SSHResult, error) {
+	var result SSHResult
+	var lastErr error
+	pollErr := wait.PollImmediate(interval, timeout, func() (bool, error) {
+		var sshErr error
+		result, sshErr = SSH(cmd, host, provider)
+		if sshErr != nil {
+			lastErr = sshErr
+			return false, nil
+		}
+		if checkErr := check(result); checkErr != nil {
+			lastErr = checkErr
+			return false, nil
+		}
+		return true, nil
+	})
+	if pollErr != nil {
+		return result, fmt.Errorf("SSHUntil: %q on %s never succeeded within %v: %v", cmd, host, timeout, lastErr)
+	}
+	return result, nil
+}
+
+// SSHExpectStdoutContains is SSHUntil with a check that waits for substr to
+// appear in the command's stdout.
+func SSHExpectStdoutContains(cmd, host, provider, substr string, timeout, interval time.Duration) (SSHResult, error) {
+	return SSHUntil(cmd, host, provider, func(result SSHResult) error {
+		if !strings.Contains(result.Stdout, substr) {
+			return fmt.Errorf("stdout %q does not contain %q", result.Stdout, substr)
+		}
+		return nil
+	}, timeout, interval)
+}
+
+// SSHExpectExitCode is SSHUntil with a check that waits for cmd to exit with
+// wantCode.
+func SSHExpectExitCode(cmd, host, provider string, wantCode int, timeout, interval time.Duration) (SSHResult, error) {
+	return SSHUntil(cmd, host, provider, func(result SSHResult) error {
+		if result.Code != wantCode {
+			return fmt.Errorf("exit code %d, want %d", result.Code, wantCode)
+		}
+		return nil
+	}, timeout, interval)
+}
+
+// SSHUntilOnHosts runs SSHUntil against every host in hosts concurrently,
+// bounded to maxWorkers requests in flight at a time, and returns one
+// SSHResult/error pair per host, in the same order as hosts.
+func SSHUntilOnHosts(cmd string, hosts []string, provider string, check func(SSHResult) error, timeout, interval time.Duration, maxWorkers int) ([]SSHResult, []error) {
+	results := make([]SSHResult, len(hosts))
+	errs := make([]error, len(hosts))
+
+	if maxWorkers <= 0 {
+		maxWorkers = 1
+	}
+	sem := make(chan struct{}, maxWorkers)
+
+	var wg sync.WaitGroup
+	for i, host := range hosts {
+		wg.Add(1)
+		go func(i int, host string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			results[i], errs[i] = SSHUntil(cmd, host, provider, check, timeout, interval)
+		}(i, host)
+	}
+	wg.Wait()
+
+	return results, errs
+}
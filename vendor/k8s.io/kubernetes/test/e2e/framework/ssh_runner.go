@@ -0,0 +1,315 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package framework
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	clientgoexec "k8s.io/client-go/util/exec"
+
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// CommandRunner abstracts the ways the e2e suite can execute a command
+// against a node: SSH (direct or via bastion), a local exec for providers
+// where the test binary already runs on the node (e.g. some bare-metal and
+// kind setups), or a privileged debug pod that nsenters the node's PID 1
+// namespaces via kubectl exec. Tests that only need "run a command on the
+// node" should depend on this interface instead of calling SSH directly, so
+// that they keep working on providers without SSH signer support.
+type CommandRunner interface {
+	// Run executes cmd on the node and returns its result.
+	Run(cmd string) (SSHResult, error)
+	// Copy copies the local file at src to dst on the node.
+	Copy(src, dst string) error
+	// Close releases any resources the runner created for this node (e.g. a
+	// debug pod). Callers must call it, typically from test teardown, once
+	// they're done issuing commands.
+	Close() error
+}
+
+// CommandRunnerFactory builds the CommandRunner for a single node. c is
+// provided for implementations (like the debug pod runner) that need API
+// access; host is the SSH-able address used by the ssh-based runners.
+type CommandRunnerFactory func(c clientset.Interface, nodeName, host, provider string) (CommandRunner, error)
+
+var nodeCommandRunnerOverride = flag.String("node-command-runner", "",
+	"Force the CommandRunner implementation used to run commands on nodes (ssh, exec, kubectl-debug-pod, "+
+		"or a name registered via RegisterCommandRunner), overriding the default inferred from --provider.")
+
+var (
+	commandRunnersMu sync.Mutex
+	commandRunners   = map[string]CommandRunnerFactory{
+		"ssh":               newSSHCommandRunner,
+		"exec":              newLocalCommandRunner,
+		"kubectl-debug-pod": newKubectlDebugPodRunner,
+	}
+)
+
+// RegisterCommandRunner lets an out-of-tree provider plug in its own
+// CommandRunner implementation under name, selectable via
+// --node-command-runner=name or as the default for TestContext.Provider ==
+// name (see defaultCommandRunnerName).
+func RegisterCommandRunner(name string, factory CommandRunnerFactory) {
+	commandRunnersMu.Lock()
+	defer commandRunnersMu.Unlock()
+	commandRunners[name] = factory
+}
+
+// NewNodeCommandRunner returns the CommandRunner to use for nodeName/host,
+// chosen by --node-command-runner if set, otherwise inferred from
+// TestContext.Provider.
+func NewNodeCommandRunner(c clientset.Interface, nodeName, host string) (CommandRunner, error) {
+	commandRunnersMu.Lock()
+	name := *nodeCommandRunnerOverride
+	if name == "" {
+		name = defaultCommandRunnerName(TestContext.Provider)
+	}
+	factory, ok := commandRunners[name]
+	commandRunnersMu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("no CommandRunner registered for %q", name)
+	}
+	return factory(c, nodeName, host, TestContext.Provider)
+}
+
+// defaultCommandRunnerName picks the runner registered under provider's own
+// name if there is one, "kubectl-debug-pod" for providers known not to have
+// SSH signer support (see GetSigner), and "ssh" for everything else. Callers
+// must hold commandRunnersMu.
+func defaultCommandRunnerName(provider string) string {
+	if _, ok := commandRunners[provider]; ok {
+		return provider
+	}
+	switch provider {
+	case "kind", "bare-metal", "minikube":
+		return "kubectl-debug-pod"
+	default:
+		return "ssh"
+	}
+}
+
+// sshCommandRunner runs commands over SSH, optionally via KUBE_SSH_BASTION;
+// see SSH.
+type sshCommandRunner struct {
+	host     string
+	provider string
+}
+
+func newSSHCommandRunner(_ clientset.Interface, _, host, provider string) (CommandRunner, error) {
+	return &sshCommandRunner{host: host, provider: provider}, nil
+}
+
+func (r *sshCommandRunner) Run(cmd string) (SSHResult, error) {
+	return SSH(cmd, r.host, r.provider)
+}
+
+func (r *sshCommandRunner) Copy(src, dst string) error {
+	return SCPToNode(src, dst, r.host, r.provider)
+}
+
+func (r *sshCommandRunner) Close() error {
+	return nil
+}
+
+// localCommandRunner runs commands in the test binary's own shell, for
+// providers where the node under test is effectively localhost.
+type localCommandRunner struct{}
+
+func newLocalCommandRunner(_ clientset.Interface, _, host, _ string) (CommandRunner, error) {
+	return localCommandRunner{}, nil
+}
+
+func (localCommandRunner) Run(cmd string) (SSHResult, error) {
+	result := SSHResult{Host: "localhost", Cmd: cmd}
+	var bout, berr bytes.Buffer
+	c := exec.Command("/bin/sh", "-c", cmd)
+	c.Stdout, c.Stderr = &bout, &berr
+	err := c.Run()
+	result.Stdout, result.Stderr = bout.String(), berr.String()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		result.Code = exitErr.ExitCode()
+		err = nil
+	}
+	return result, err
+}
+
+func (localCommandRunner) Copy(src, dst string) error {
+	data, err := ioutil.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(dst, data, 0644)
+}
+
+func (localCommandRunner) Close() error {
+	return nil
+}
+
+// kubectlDebugPodRunner runs commands on a node by scheduling a privileged,
+// hostPID+hostNetwork debug pod onto it and nsentering into PID 1's mount,
+// UTS, network, and IPC namespaces before running cmd. It is the fallback for
+// providers where SSH access to nodes isn't available, such as bare-metal,
+// kind, and the minikube Docker driver.
+type kubectlDebugPodRunner struct {
+	client clientset.Interface
+	host   string
+	pod    *v1.Pod
+}
+
+func newKubectlDebugPodRunner(c clientset.Interface, nodeName, host, _ string) (CommandRunner, error) {
+	pod, err := createNodeDebugPod(c, nodeName)
+	if err != nil {
+		return nil, err
+	}
+	return &kubectlDebugPodRunner{client: c, host: host, pod: pod}, nil
+}
+
+func createNodeDebugPod(c clientset.Interface, nodeName string) (*v1.Pod, error) {
+	privileged := true
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: "node-debug-",
+			Namespace:    metav1.NamespaceSystem,
+		},
+		Spec: v1.PodSpec{
+			NodeName:      nodeName,
+			HostPID:       true,
+			HostNetwork:   true,
+			RestartPolicy: v1.RestartPolicyNever,
+			Tolerations:   []v1.Toleration{{Operator: v1.TolerationOpExists}},
+			Containers: []v1.Container{{
+				Name:            "debug",
+				Image:           "registry.k8s.io/e2e-test-images/agnhost:2.39",
+				Command:         []string{"sleep", "3600"},
+				SecurityContext: &v1.SecurityContext{Privileged: &privileged},
+			}},
+		},
+	}
+
+	pod, err := c.CoreV1().Pods(metav1.NamespaceSystem).Create(context.TODO(), pod, metav1.CreateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error creating node debug pod on %s: %v", nodeName, err)
+	}
+
+	err = wait.PollImmediate(2*time.Second, 2*time.Minute, func() (bool, error) {
+		pod, err = c.CoreV1().Pods(pod.Namespace).Get(context.TODO(), pod.Name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		return pod.Status.Phase == v1.PodRunning, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("node debug pod on %s never became ready: %v", nodeName, err)
+	}
+	return pod, nil
+}
+
+func (r *kubectlDebugPodRunner) Run(cmd string) (SSHResult, error) {
+	stdout, stderr, code, err := r.exec(nsenterShellCommand(cmd), nil)
+	return SSHResult{Host: r.host, Cmd: cmd, Stdout: stdout, Stderr: stderr, Code: code}, err
+}
+
+func (r *kubectlDebugPodRunner) Copy(src, dst string) error {
+	local, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer local.Close()
+
+	// Stream the file over stdin rather than embedding it (even base64'd) in
+	// argv: the core files and journald dumps this is meant for routinely
+	// exceed ARG_MAX.
+	cmd := nsenterShellCommand(fmt.Sprintf("cat > %s", dst))
+	_, stderr, code, err := r.exec(cmd, local)
+	if err != nil {
+		return err
+	}
+	if code != 0 {
+		return fmt.Errorf("copying to %s:%s failed: %s", r.host, dst, stderr)
+	}
+	return nil
+}
+
+func (r *kubectlDebugPodRunner) Close() error {
+	err := r.client.CoreV1().Pods(r.pod.Namespace).Delete(context.TODO(), r.pod.Name, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("error deleting node debug pod %s: %v", r.pod.Name, err)
+	}
+	return nil
+}
+
+// nsenterShellCommand wraps cmd in /bin/sh -c inside the node's namespaces,
+// the same way the pod's own /bin/sh -c wraps commands, so pipes, redirects,
+// and globs in cmd are interpreted on the node rather than by the debug pod.
+func nsenterShellCommand(cmd string) string {
+	return fmt.Sprintf("nsenter -t 1 -m -u -n -i -- /bin/sh -c %s", shellQuote(cmd))
+}
+
+// shellQuote single-quotes s for use as one POSIX shell word, escaping any
+// single quotes it contains.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'"'"'`) + "'"
+}
+
+func (r *kubectlDebugPodRunner) exec(cmd string, stdin io.Reader) (stdout, stderr string, code int, err error) {
+	config, err := LoadConfig()
+	if err != nil {
+		return "", "", 0, fmt.Errorf("error loading REST config: %v", err)
+	}
+
+	req := r.client.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(r.pod.Namespace).
+		Name(r.pod.Name).
+		SubResource("exec").
+		VersionedParams(&v1.PodExecOptions{
+			Container: r.pod.Spec.Containers[0].Name,
+			Command:   []string{"/bin/sh", "-c", cmd},
+			Stdin:     stdin != nil,
+			Stdout:    true,
+			Stderr:    true,
+		}, scheme.ParameterCodec)
+
+	executor, err := remotecommand.NewSPDYExecutor(config, "POST", req.URL())
+	if err != nil {
+		return "", "", 0, fmt.Errorf("error creating executor for %s: %v", r.pod.Name, err)
+	}
+
+	var bout, berr bytes.Buffer
+	err = executor.Stream(remotecommand.StreamOptions{Stdin: stdin, Stdout: &bout, Stderr: &berr})
+	if exitErr, ok := err.(clientgoexec.ExitError); ok {
+		return bout.String(), berr.String(), exitErr.ExitStatus(), nil
+	}
+	return bout.String(), berr.String(), 0, err
+}
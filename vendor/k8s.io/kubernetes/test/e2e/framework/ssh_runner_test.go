@@ -0,0 +1,74 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package framework
+
+import (
+	"os/exec"
+	"testing"
+)
+
+func TestShellQuote(t *testing.T) {
+	cases := []struct {
+		in   string
+		want string
+	}{
+		{"hello", "'hello'"},
+		{"a b", "'a b'"},
+		{"it's", `'it'"'"'s'`},
+		{"", "''"},
+		{"$(rm -rf /)", "'$(rm -rf /)'"},
+	}
+	for _, c := range cases {
+		if got := shellQuote(c.in); got != c.want {
+			t.Errorf("shellQuote(%q) = %q, want %q", c.in, got, c.want)
+		}
+	}
+}
+
+// TestShellQuoteRoundTrip checks shellQuote against an actual shell rather
+// than just the literal escaping rules, since quoting bugs tend to only show
+// up once a real shell re-tokenizes the result.
+func TestShellQuoteRoundTrip(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no sh on PATH")
+	}
+
+	cases := []string{
+		"hello world",
+		"it's a trap",
+		"a | b && c || d; e",
+		"glob * not expanded literally? depends, skip globs",
+		`double "quotes" inside`,
+	}
+	for _, in := range cases {
+		out, err := exec.Command("sh", "-c", "printf %s "+shellQuote(in)).Output()
+		if err != nil {
+			t.Fatalf("sh -c failed for input %q: %v", in, err)
+		}
+		if string(out) != in {
+			t.Errorf("round-tripping %q through shellQuote got %q", in, string(out))
+		}
+	}
+}
+
+func TestNsenterShellCommand(t *testing.T) {
+	got := nsenterShellCommand("echo hi")
+	want := "nsenter -t 1 -m -u -n -i -- /bin/sh -c 'echo hi'"
+	if got != want {
+		t.Errorf("nsenterShellCommand(%q) = %q, want %q", "echo hi", got, want)
+	}
+}
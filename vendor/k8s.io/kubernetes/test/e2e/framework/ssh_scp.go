@@ -0,0 +1,141 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package framework
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// SCPToNode copies the local file at localPath to remotePath on host via
+// SFTP, honoring KUBE_SSH_BASTION the same way SSH does. Many tests have
+// historically worked around the lack of file transfer by base64-encoding
+// payloads into `echo | sudo tee` pipelines run through SSH; SFTP is
+// dramatically faster for anything beyond a few kilobytes.
+func SCPToNode(localPath, remotePath, host, provider string) error {
+	return withSFTPClient(host, provider, func(client *sftp.Client) error {
+		local, err := os.Open(localPath)
+		if err != nil {
+			return err
+		}
+		defer local.Close()
+
+		remote, err := client.Create(remotePath)
+		if err != nil {
+			return fmt.Errorf("error creating %s on %s: %v", remotePath, host, err)
+		}
+		defer remote.Close()
+
+		if _, err := io.Copy(remote, local); err != nil {
+			return fmt.Errorf("error copying %s to %s:%s: %v", localPath, host, remotePath, err)
+		}
+		return nil
+	})
+}
+
+// SCPFromNode copies remotePath on host to the local file at localPath via
+// SFTP, honoring KUBE_SSH_BASTION the same way SSH does.
+func SCPFromNode(remotePath, localPath, host, provider string) error {
+	return withSFTPClient(host, provider, func(client *sftp.Client) error {
+		remote, err := client.Open(remotePath)
+		if err != nil {
+			return fmt.Errorf("error opening %s on %s: %v", remotePath, host, err)
+		}
+		defer remote.Close()
+
+		local, err := os.Create(localPath)
+		if err != nil {
+			return err
+		}
+		defer local.Close()
+
+		if _, err := io.Copy(local, remote); err != nil {
+			return fmt.Errorf("error copying %s:%s to %s: %v", host, remotePath, localPath, err)
+		}
+		return nil
+	})
+}
+
+// SSHResultWithArtifacts behaves like SSH, additionally pulling each of
+// remotePaths from host into a same-named file under artifactsDir once cmd
+// has run. It's meant for post-failure hooks that want to grab diagnostics
+// (journald dumps, audit logs, core files) straight off a node without an
+// extra round of ad-hoc SSH calls. A failure to collect any individual
+// artifact is logged rather than turned into an error, so one missing path
+// doesn't hide the result of cmd itself.
+func SSHResultWithArtifacts(cmd, host, provider, artifactsDir string, remotePaths []string) (SSHResult, error) {
+	result, err := SSH(cmd, host, provider)
+	if err != nil {
+		return result, err
+	}
+
+	for _, remotePath := range remotePaths {
+		localPath := filepath.Join(artifactsDir, filepath.Base(remotePath))
+		if scpErr := SCPFromNode(remotePath, localPath, host, provider); scpErr != nil {
+			Logf("error collecting artifact %s from %s: %v", remotePath, host, scpErr)
+		}
+	}
+
+	return result, nil
+}
+
+// withSFTPClient gets a (possibly pooled) SSH client to host - through
+// KUBE_SSH_BASTION if set - opens an SFTP session over it, and runs fn.
+func withSFTPClient(host, provider string, fn func(*sftp.Client) error) error {
+	authMethods, err := GetSigners(provider, host)
+	if err != nil {
+		return fmt.Errorf("error getting signers for provider %s: %v", provider, err)
+	}
+	user := sshUser()
+
+	hostKeyCb, err := hostKeyCallback()
+	if err != nil {
+		return fmt.Errorf("error configuring host key verification: %v", err)
+	}
+	config := &ssh.ClientConfig{
+		User:            user,
+		Auth:            authMethods,
+		HostKeyCallback: hostKeyCb,
+		Timeout:         150 * time.Second,
+	}
+
+	var client *ssh.Client
+	if bastion := os.Getenv("KUBE_SSH_BASTION"); len(bastion) > 0 {
+		client, err = dialViaBastion(user, bastion, host, config)
+	} else {
+		client, err = pooledClient(sshClientKey{user: user, host: host}, &sshPool, func() (*ssh.Client, error) {
+			return ssh.Dial("tcp", host, config)
+		})
+	}
+	if err != nil {
+		return fmt.Errorf("error getting SSH client to %s@%s: %v", user, host, err)
+	}
+
+	sftpClient, err := sftp.NewClient(client)
+	if err != nil {
+		return fmt.Errorf("error starting SFTP session to %s@%s: %v", user, host, err)
+	}
+	defer sftpClient.Close()
+
+	return fn(sftpClient)
+}
@@ -0,0 +1,131 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package framework
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// SSHStream runs cmd on host via SSH (through KUBE_SSH_BASTION if set),
+// copying stdin to the remote command and copying its stdout/stderr directly
+// to stdout/stderr as they arrive, rather than buffering the whole payload in
+// memory the way SSH/RunSSHCommandViaBastion do. It's meant for tests feeding
+// input to a remote command (e.g. `kubectl apply -f -` on a node, or piping a
+// tarball into `tar -xf -`) or collecting large log output. If ctx is done
+// before the command completes, its session is torn down, which kills the
+// remote command; the underlying client (shared with other SSH/SSHStream/SCP
+// calls to the same host) is left intact. Use SSH for the simpler buffered,
+// non-cancellable case.
+func SSHStream(ctx context.Context, cmd, host, provider string, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	authMethods, err := GetSigners(provider, host)
+	if err != nil {
+		return 0, fmt.Errorf("error getting signers for provider %s: %v", provider, err)
+	}
+	user := sshUser()
+
+	if bastion := os.Getenv("KUBE_SSH_BASTION"); len(bastion) > 0 {
+		return streamSSHCommandViaBastion(ctx, cmd, user, bastion, host, authMethods, stdin, stdout, stderr)
+	}
+	return streamSSHCommand(ctx, cmd, user, host, authMethods, stdin, stdout, stderr)
+}
+
+func streamSSHCommand(ctx context.Context, cmd, user, host string, authMethods []ssh.AuthMethod, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	dial := func() (*ssh.Client, error) {
+		hostKeyCb, err := hostKeyCallback()
+		if err != nil {
+			return nil, fmt.Errorf("error configuring host key verification: %v", err)
+		}
+		return ssh.Dial("tcp", host, &ssh.ClientConfig{
+			User:            user,
+			Auth:            authMethods,
+			HostKeyCallback: hostKeyCb,
+			Timeout:         150 * time.Second,
+		})
+	}
+
+	// Route through pooledSession, not pooledClient+NewSession, so a stale
+	// pooled connection gets the same evict-and-redial-once recovery as
+	// runSSHCommand.
+	session, err := pooledSession(sshClientKey{user: user, host: host}, &sshPool, dial)
+	if err != nil {
+		return 0, fmt.Errorf("error creating session to %s@%s: %v", user, host, err)
+	}
+
+	return runStream(ctx, session, cmd, user, host, stdin, stdout, stderr)
+}
+
+func streamSSHCommandViaBastion(ctx context.Context, cmd, user, bastion, host string, authMethods []ssh.AuthMethod, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	hostKeyCb, err := hostKeyCallback()
+	if err != nil {
+		return 0, fmt.Errorf("error configuring host key verification: %v", err)
+	}
+	config := &ssh.ClientConfig{
+		User:            user,
+		Auth:            authMethods,
+		HostKeyCallback: hostKeyCb,
+		Timeout:         150 * time.Second,
+	}
+
+	session, err := sessionViaBastion(user, bastion, host, config)
+	if err != nil {
+		return 0, err
+	}
+
+	return runStream(ctx, session, cmd, user, host, stdin, stdout, stderr)
+}
+
+// runStream starts cmd on an already-opened session and streams stdin in,
+// stdout/stderr out, until the command exits or ctx is done. Cancellation
+// closes only this session, never the client it came from: that client may
+// be serving other concurrent SSH/SSHStream/SCP calls to the same host, and
+// closing it out from under them would surface as spurious "use of closed
+// network connection" errors on unrelated, healthy commands.
+func runStream(ctx context.Context, session *ssh.Session, cmd, user, host string, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	defer session.Close()
+
+	session.Stdin = stdin
+	session.Stdout = stdout
+	session.Stderr = stderr
+
+	if err := session.Start(cmd); err != nil {
+		return 0, fmt.Errorf("error starting `%s` on %s@%s: %v", cmd, user, host, err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- session.Wait() }()
+
+	select {
+	case <-ctx.Done():
+		session.Close()
+		<-done
+		return 0, ctx.Err()
+	case err := <-done:
+		if exiterr, ok := err.(*ssh.ExitError); ok {
+			return exiterr.ExitStatus(), nil
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed running `%s` on %s@%s: %v", cmd, user, host, err)
+		}
+		return 0, nil
+	}
+}